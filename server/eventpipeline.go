@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CyCoreSystems/ari/v5"
+)
+
+// EventOverflowPolicy controls how the event pipeline behaves when its
+// internal queue is full.
+type EventOverflowPolicy int
+
+const (
+	// EventOverflowBlock blocks the ARI event-read loop until the queue
+	// has room, preserving full delivery at the cost of back-pressuring
+	// ARI itself.
+	EventOverflowBlock EventOverflowPolicy = iota
+
+	// EventOverflowDropOldest discards the oldest queued event to make
+	// room for the incoming one.
+	EventOverflowDropOldest
+
+	// EventOverflowDropNewest discards the incoming event, leaving the
+	// queue untouched.
+	EventOverflowDropNewest
+
+	// EventOverflowCoalesceByKind, once the queue is full, collapses events
+	// of the same kind for the same channel or bridge (e.g. bursts of
+	// ChannelVarset, ChannelDtmfReceived), keeping only the most recent,
+	// flushed in FIFO order by the publisher workers.  It applies to every
+	// event kind, not only high-frequency ones.
+	EventOverflowCoalesceByKind
+)
+
+// eventEnvelope pairs an ARI event with the dialogs it was resolved to, so
+// publisher workers need not re-resolve dialog membership.
+type eventEnvelope struct {
+	event   ari.Event
+	dialogs []string
+}
+
+// coalesceKey identifies which in-flight coalesced event env should replace,
+// keyed by event kind plus the channel/bridge entity the event actually
+// pertains to.  Keying on the resolved dialogs instead would collide events
+// from unrelated channels that happen to share a dialog (or that have none
+// at all), clobbering an unrelated still-pending event.
+func coalesceKey(env eventEnvelope) string {
+	return env.event.GetType() + "|" + entityKey(env)
+}
+
+// entityKey returns a stable identifier for the channel or bridge an event
+// pertains to.  It falls back to the event's resolved dialogs for events
+// which expose no entity IDs of their own (e.g. application-scoped events).
+func entityKey(env eventEnvelope) string {
+	switch e := env.event.(type) {
+	case ari.ChannelEvent:
+		if ids := e.GetChannelIDs(); len(ids) > 0 {
+			return strings.Join(ids, ",")
+		}
+	case ari.BridgeEvent:
+		if ids := e.GetBridgeIDs(); len(ids) > 0 {
+			return strings.Join(ids, ",")
+		}
+	}
+	return strings.Join(env.dialogs, ",")
+}
+
+// eventPipeline relays events from runEventHandler to EventPublishers worker
+// goroutines through a bounded channel, applying EventOverflowPolicy when
+// the channel is full, so that a slow MessageBus publisher cannot stall ARI
+// event consumption.
+type eventPipeline struct {
+	queue    chan eventEnvelope
+	policy   EventOverflowPolicy
+	stats    StatsHook
+	inFlight int32
+
+	coalesceMu sync.Mutex
+	coalesced  map[string]eventEnvelope
+	pending    chan string
+
+	enqueued   uint64
+	published  uint64
+	dropped    uint64
+	coalescedN uint64
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newEventPipeline constructs an eventPipeline with the given queue depth
+// and overflow policy.
+func newEventPipeline(depth int, policy EventOverflowPolicy, stats StatsHook) *eventPipeline {
+	if depth < 1 {
+		depth = 1
+	}
+	return &eventPipeline{
+		queue:     make(chan eventEnvelope, depth),
+		policy:    policy,
+		stats:     stats,
+		coalesced: make(map[string]eventEnvelope),
+		pending:   make(chan string, depth),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// enqueue admits env to the pipeline, applying the configured overflow
+// policy if the queue is already full.
+func (p *eventPipeline) enqueue(env eventEnvelope) {
+	defer p.report()
+
+	switch p.policy {
+	case EventOverflowCoalesceByKind:
+		// enqueueCoalesced does its own enqueued/dropped/coalesced counting
+		p.enqueueCoalesced(env)
+		return
+	case EventOverflowDropOldest:
+		select {
+		case p.queue <- env:
+		default:
+			select {
+			case <-p.queue:
+				atomic.AddUint64(&p.dropped, 1)
+			default:
+			}
+			select {
+			case p.queue <- env:
+			default:
+			}
+		}
+	case EventOverflowDropNewest:
+		select {
+		case p.queue <- env:
+		default:
+			atomic.AddUint64(&p.dropped, 1)
+			return
+		}
+	default: // EventOverflowBlock
+		p.queue <- env
+	}
+
+	atomic.AddUint64(&p.enqueued, 1)
+}
+
+// enqueueCoalesced implements EventOverflowCoalesceByKind: once the queue is
+// full, events are held in a small map keyed by coalesceKey (kind plus
+// channel/bridge entity) rather than the
+// channel, so that only the most recent event per key survives until a
+// worker is free to flush it.
+func (p *eventPipeline) enqueueCoalesced(env eventEnvelope) {
+	select {
+	case p.queue <- env:
+		atomic.AddUint64(&p.enqueued, 1)
+		return
+	default:
+	}
+
+	key := coalesceKey(env)
+
+	p.coalesceMu.Lock()
+	_, exists := p.coalesced[key]
+	p.coalesced[key] = env
+	p.coalesceMu.Unlock()
+
+	if exists {
+		atomic.AddUint64(&p.coalescedN, 1)
+		return
+	}
+
+	select {
+	case p.pending <- key:
+		atomic.AddUint64(&p.enqueued, 1)
+	default:
+		// pending is sized to match queue capacity; if it is also full
+		// there is nothing left to coalesce into, so drop it.
+		p.coalesceMu.Lock()
+		delete(p.coalesced, key)
+		p.coalesceMu.Unlock()
+		atomic.AddUint64(&p.dropped, 1)
+	}
+}
+
+// run drains the pipeline, invoking publish for each event taken from the
+// queue or from the coalesce backlog, until the pipeline is stopped via
+// drain.  Each of those two sources is read in FIFO order, but the order
+// between them, and between several run goroutines, is not defined.  It has its own stopCh rather
+// than taking a context directly, so that draining can be decoupled from
+// whatever context governed the listen() call which started it.
+func (p *eventPipeline) run(publish func(eventEnvelope)) {
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case env := <-p.queue:
+			p.publishOne(env, publish)
+		case key := <-p.pending:
+			p.coalesceMu.Lock()
+			env, ok := p.coalesced[key]
+			delete(p.coalesced, key)
+			p.coalesceMu.Unlock()
+
+			if ok {
+				p.publishOne(env, publish)
+			}
+		}
+	}
+}
+
+// publishOne publishes env, tracking it as in-flight so that drain can
+// tell when the pipeline has fully quiesced.
+func (p *eventPipeline) publishOne(env eventEnvelope, publish func(eventEnvelope)) {
+	atomic.AddInt32(&p.inFlight, 1)
+	publish(env)
+	atomic.AddInt32(&p.inFlight, -1)
+
+	atomic.AddUint64(&p.published, 1)
+	p.report()
+}
+
+// drain waits, bounded by ctx, for the queue, pending coalesce map, and any
+// in-flight publish to empty out, then stops the pipeline's run goroutines.
+// It is safe to call multiple times.
+func (p *eventPipeline) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	var err error
+	for {
+		p.coalesceMu.Lock()
+		pendingCoalesced := len(p.coalesced)
+		p.coalesceMu.Unlock()
+
+		if len(p.queue) == 0 && len(p.pending) == 0 && pendingCoalesced == 0 && atomic.LoadInt32(&p.inFlight) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	return err
+}
+
+// report emits pipeline counters through the configured StatsHook, if any.
+func (p *eventPipeline) report() {
+	if p.stats == nil {
+		return
+	}
+	p.stats("event_pipeline.queue_depth", float64(len(p.queue)))
+	p.stats("event_pipeline.enqueued", float64(atomic.LoadUint64(&p.enqueued)))
+	p.stats("event_pipeline.published", float64(atomic.LoadUint64(&p.published)))
+	p.stats("event_pipeline.dropped", float64(atomic.LoadUint64(&p.dropped)))
+	p.stats("event_pipeline.coalesced", float64(atomic.LoadUint64(&p.coalescedN)))
+}
@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/ari-proxy/v5/proxy"
+	"github.com/rotisserie/eris"
+)
+
+// ErrUnknownPeer is returned when a request targets an AsteriskID which is
+// neither this server's own AsteriskID nor a known, federated peer.
+var ErrUnknownPeer = eris.New("no known peer for requested asterisk id")
+
+// ErrForwardLoop is returned when a request has already been relayed
+// MaxForwardHops times, to prevent it from looping between peers forever.
+var ErrForwardLoop = eris.New("maximum forward hops exceeded")
+
+// ErrForwardUnauthorized is returned when a request arrives bearing a
+// forward token which does not match this server's configured ForwardToken.
+var ErrForwardUnauthorized = eris.New("forward token is missing or invalid")
+
+// metadataHopsKey and metadataTokenKey are the proxy.Request.Metadata keys
+// under which forwarded requests carry their hop count and bearer token.
+// Neither is carried on proxy.Announcement: the hop count is meaningless
+// outside of a single request's journey, and the token must never be
+// broadcast somewhere every subscriber can read it.
+const (
+	metadataHopsKey  = "ariproxy.hops"
+	metadataTokenKey = "ariproxy.forwardToken"
+)
+
+// peerInfo records what runPeerListener has learned about a peer Server
+// from its periodic proxy.Announcement.
+type peerInfo struct {
+	Application string
+	LastSeen    time.Time
+}
+
+// peerRegistry tracks known peer proxies by AsteriskID, pruning entries
+// which have not announced themselves recently.  It is safe for concurrent
+// use.
+type peerRegistry struct {
+	mu    sync.RWMutex
+	peers map[string]peerInfo
+}
+
+func newPeerRegistry() *peerRegistry {
+	return &peerRegistry{peers: make(map[string]peerInfo)}
+}
+
+func (r *peerRegistry) update(a *proxy.Announcement) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.peers[a.Node] = peerInfo{
+		Application: a.Application,
+		LastSeen:    time.Now(),
+	}
+}
+
+func (r *peerRegistry) lookup(asteriskID string) (peerInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, ok := r.peers[asteriskID]
+	return p, ok
+}
+
+// prune removes peers which have not been seen within maxAge
+func (r *peerRegistry) prune(maxAge time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, p := range r.peers {
+		if time.Since(p.LastSeen) > maxAge {
+			delete(r.peers, id)
+		}
+	}
+}
+
+// runPeerListener subscribes to peer proxy.Announcement messages and
+// populates s.peers, pruning entries which have gone silent for more than
+// AnnouncementInterval*3.
+func (s *Server) runPeerListener(ctx context.Context) {
+	sub, err := s.mbus.SubscribeAnnounce(proxy.AnnouncementSubject(s.MBPrefix), func(a *proxy.Announcement) {
+		if a.Node == "" || a.Node == s.AsteriskID {
+			return
+		}
+		s.peers.update(a)
+	})
+	if err != nil {
+		s.Log.Error("failed to subscribe to peer announcements", "error", err)
+		return
+	}
+	defer sub.Unsubscribe()
+
+	ticker := time.NewTicker(proxy.AnnouncementInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.peers.prune(proxy.AnnouncementInterval * 3)
+		}
+	}
+}
+
+// registerFederation installs the forwarding middleware: a request which
+// targets a different, known peer's AsteriskID is relayed to that peer over
+// MessageBus instead of being dispatched locally, and a request which has
+// already been forwarded to us must carry a valid ForwardToken.  It is a
+// no-op whenever Federation is disabled, so it is safe to register
+// unconditionally.
+func (s *Server) registerFederation() {
+	s.Use(func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, reply string, req *proxy.Request) {
+			if !s.Federation {
+				next(ctx, reply, req)
+				return
+			}
+
+			if req.AsteriskID != "" && req.AsteriskID != s.AsteriskID {
+				s.forwardRequest(ctx, reply, req)
+				return
+			}
+
+			if requestHops(req) > 0 && !s.validForwardToken(req) {
+				s.sendError(reply, ErrForwardUnauthorized)
+				return
+			}
+
+			next(ctx, reply, req)
+		}
+	})
+}
+
+// forwardRequest relays req to the peer which owns req.AsteriskID, honoring
+// MaxForwardHops to prevent forwarding loops, and lets the peer stream its
+// response directly back to reply.
+func (s *Server) forwardRequest(ctx context.Context, reply string, req *proxy.Request) {
+	peer, ok := s.peers.lookup(req.AsteriskID)
+	if !ok {
+		s.sendError(reply, ErrUnknownPeer)
+		return
+	}
+
+	hops := requestHops(req)
+	if hops >= s.MaxForwardHops {
+		s.sendError(reply, ErrForwardLoop)
+		return
+	}
+
+	fwd := *req
+	fwd.Metadata = cloneMetadata(req.Metadata)
+	fwd.Metadata[metadataHopsKey] = strconv.Itoa(hops + 1)
+	if token, ok := s.PeerTokens[req.AsteriskID]; ok {
+		fwd.Metadata[metadataTokenKey] = token
+	} else {
+		delete(fwd.Metadata, metadataTokenKey)
+	}
+
+	subject := proxy.Subject(s.MBPrefix, requestCategory(ctx, s.MBPrefix), peer.Application, req.AsteriskID)
+	if err := s.mbus.PublishRequest(subject, reply, &fwd); err != nil {
+		s.sendError(reply, eris.Wrap(err, "failed to forward request to peer"))
+	}
+}
+
+// requestHops returns the number of times req has already been relayed
+// between federated peers, as carried in req.Metadata.
+func requestHops(req *proxy.Request) int {
+	v, ok := req.Metadata[metadataHopsKey]
+	if !ok {
+		return 0
+	}
+	hops, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return hops
+}
+
+// validForwardToken reports whether req carries the ForwardToken this
+// server requires of forwarded requests.  If ForwardToken is unset, no
+// token is required.  The comparison is constant-time so that this check
+// cannot be used to brute-force the configured token.
+func (s *Server) validForwardToken(req *proxy.Request) bool {
+	if s.ForwardToken == "" {
+		return true
+	}
+	token := req.Metadata[metadataTokenKey]
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.ForwardToken)) == 1
+}
+
+// cloneMetadata returns a copy of m, so that mutating a forwarded request's
+// metadata never mutates the original request's.
+func cloneMetadata(m map[string]string) map[string]string {
+	clone := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
+
+// requestSubjectCtxKey is the context key under which newRequestHandler
+// stashes the MessageBus subject a request arrived on, so that forwarding
+// can rebuild the equivalent subject for the target peer.
+type requestSubjectCtxKey struct{}
+
+func withRequestSubject(ctx context.Context, subject string) context.Context {
+	return context.WithValue(ctx, requestSubjectCtxKey{}, subject)
+}
+
+// requestCategory recovers the "get"/"data"/"command"/"create" category
+// from the subject a request originally arrived on.
+func requestCategory(ctx context.Context, prefix string) string {
+	subject, _ := ctx.Value(requestSubjectCtxKey{}).(string)
+	category := strings.TrimPrefix(subject, prefix)
+	if idx := strings.IndexByte(category, '.'); idx >= 0 {
+		category = category[:idx]
+	}
+	return category
+}
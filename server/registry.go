@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CyCoreSystems/ari-proxy/v5/proxy"
+)
+
+// RequestHandler handles a single proxy.Request, publishing its response (if
+// any) to the given reply subject.
+type RequestHandler func(ctx context.Context, reply string, req *proxy.Request)
+
+// Middleware wraps a RequestHandler to add cross-cutting behavior (auth,
+// tracing, metrics, rate limiting, and so on).  Middlewares are applied in
+// the order they were registered with Server.Use, so the first-registered
+// middleware is the outermost wrapper.
+type Middleware func(RequestHandler) RequestHandler
+
+// HandlerRegistry maps request Kinds to the RequestHandler which services
+// them, and holds the middleware chain through which every dispatched
+// request is run.  It is safe for concurrent use.
+type HandlerRegistry struct {
+	mu          sync.RWMutex
+	handlers    map[string]RequestHandler
+	middlewares []Middleware
+
+	// fallback handles any Kind for which no handler is registered
+	fallback RequestHandler
+}
+
+// newHandlerRegistry returns an empty HandlerRegistry which falls back to
+// fallback for any unregistered request Kind.
+func newHandlerRegistry(fallback RequestHandler) *HandlerRegistry {
+	return &HandlerRegistry{
+		handlers: make(map[string]RequestHandler),
+		fallback: fallback,
+	}
+}
+
+// handle registers fn as the handler for the given request Kind, replacing
+// any handler previously registered for that Kind.
+func (r *HandlerRegistry) handle(kind string, fn RequestHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = fn
+}
+
+// use appends mw to the middleware chain
+func (r *HandlerRegistry) use(mw Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middlewares = append(r.middlewares, mw)
+}
+
+// dispatch looks up the handler for req.Kind, wraps it in the registered
+// middleware chain, and invokes it.  Requests of an unregistered Kind fall
+// through to the registry's fallback handler.
+func (r *HandlerRegistry) dispatch(ctx context.Context, reply string, req *proxy.Request) {
+	r.mu.RLock()
+	fn, ok := r.handlers[req.Kind]
+	mws := r.middlewares
+	r.mu.RUnlock()
+
+	if !ok {
+		fn = r.fallback
+	}
+
+	for i := len(mws) - 1; i >= 0; i-- {
+		fn = mws[i](fn)
+	}
+
+	fn(ctx, reply, req)
+}
+
+// Handle registers fn as the handler for the given request Kind.  It may be
+// called to override a built-in handler or to add support for an entirely
+// new, non-standard Kind (e.g. "ChannelHoldWithMusic").
+func (s *Server) Handle(kind string, fn RequestHandler) {
+	s.registry.handle(kind, fn)
+}
+
+// Use appends mw to the server's middleware chain.  Middlewares run in
+// registration order, outermost first, around every dispatched request.
+func (s *Server) Use(mw Middleware) {
+	s.registry.use(mw)
+}
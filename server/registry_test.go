@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CyCoreSystems/ari-proxy/v5/proxy"
+)
+
+func TestHandlerRegistryDispatchesRegisteredHandler(t *testing.T) {
+	var called bool
+	var fallbackCalled bool
+
+	r := newHandlerRegistry(func(ctx context.Context, reply string, req *proxy.Request) {
+		fallbackCalled = true
+	})
+	r.handle("ChannelGet", func(ctx context.Context, reply string, req *proxy.Request) {
+		called = true
+	})
+
+	r.dispatch(context.Background(), "reply", &proxy.Request{Kind: "ChannelGet"})
+
+	if !called {
+		t.Fatal("expected registered handler to be invoked")
+	}
+	if fallbackCalled {
+		t.Fatal("expected fallback not to be invoked")
+	}
+}
+
+func TestHandlerRegistryFallsBackForUnregisteredKind(t *testing.T) {
+	var fallbackCalled bool
+
+	r := newHandlerRegistry(func(ctx context.Context, reply string, req *proxy.Request) {
+		fallbackCalled = true
+	})
+
+	r.dispatch(context.Background(), "reply", &proxy.Request{Kind: "SomethingUnknown"})
+
+	if !fallbackCalled {
+		t.Fatal("expected fallback to be invoked for an unregistered kind")
+	}
+}
+
+func TestHandlerRegistryHandleOverridesPreviousRegistration(t *testing.T) {
+	var calledFirst, calledSecond bool
+
+	r := newHandlerRegistry(nil)
+	r.handle("ChannelGet", func(ctx context.Context, reply string, req *proxy.Request) {
+		calledFirst = true
+	})
+	r.handle("ChannelGet", func(ctx context.Context, reply string, req *proxy.Request) {
+		calledSecond = true
+	})
+
+	r.dispatch(context.Background(), "reply", &proxy.Request{Kind: "ChannelGet"})
+
+	if calledFirst {
+		t.Fatal("expected first-registered handler to have been replaced")
+	}
+	if !calledSecond {
+		t.Fatal("expected second-registered handler to be invoked")
+	}
+}
+
+func TestHandlerRegistryMiddlewareWrapsOutermostFirst(t *testing.T) {
+	var order []string
+
+	r := newHandlerRegistry(nil)
+	r.handle("ChannelGet", func(ctx context.Context, reply string, req *proxy.Request) {
+		order = append(order, "handler")
+	})
+	r.use(func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, reply string, req *proxy.Request) {
+			order = append(order, "outer:before")
+			next(ctx, reply, req)
+			order = append(order, "outer:after")
+		}
+	})
+	r.use(func(next RequestHandler) RequestHandler {
+		return func(ctx context.Context, reply string, req *proxy.Request) {
+			order = append(order, "inner:before")
+			next(ctx, reply, req)
+			order = append(order, "inner:after")
+		}
+	})
+
+	r.dispatch(context.Background(), "reply", &proxy.Request{Kind: "ChannelGet"})
+
+	want := []string{"outer:before", "inner:before", "handler", "inner:after", "outer:after"}
+	if len(order) != len(want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+}
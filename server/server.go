@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/CyCoreSystems/ari-proxy/v5/messagebus"
@@ -45,6 +48,87 @@ type Server struct {
 	Log log15.Logger
 
 	mbus messagebus.Server
+
+	// registry holds the request handlers and middleware chain through
+	// which all dispatched requests are run.  See Handle and Use.
+	registry *HandlerRegistry
+
+	// WorkerPoolSize is the number of worker goroutines dispatching
+	// requests.  Requests of a Kind held back by PerKindLimits may run on
+	// extra waiter goroutines, so up to the sum of PerKindLimits more
+	// requests than this may be running at once.
+	WorkerPoolSize int
+
+	// PerKindLimits optionally caps the concurrency of individual request
+	// Kinds (e.g. "ChannelOriginate") below WorkerPoolSize.
+	PerKindLimits map[string]int
+
+	// QueueDepth is the number of requests which may be queued for
+	// dispatch before new requests are rejected with ErrOverloaded.
+	QueueDepth int
+
+	// StatsHook, if set, receives worker-pool and event-pipeline gauge
+	// readings (queue depth, in-flight count, and so on).
+	StatsHook StatsHook
+
+	pool *WorkerPool
+
+	// DrainTimeout bounds how long listen will wait, once stopping, for the
+	// worker pool and event pipeline to finish in-flight work before giving
+	// up and returning anyway.  It is also applied when tearing down a
+	// previous pool on Reconnect.
+	DrainTimeout time.Duration
+
+	// HandleSignals, if true, causes Listen to trap SIGINT/SIGTERM and
+	// perform a graceful Shutdown rather than leaving signal handling to
+	// the embedder.
+	HandleSignals bool
+
+	// stoppedCh is closed once listen has returned and all subtended
+	// subscriptions have been unwound, so that Shutdown can report
+	// completion.
+	stoppedCh chan struct{}
+
+	// entityChanged records whether the current shutdown was triggered by
+	// runEntityChecker detecting a changed Asterisk EntityID, so that
+	// listen can return ErrEntityChanged instead of ctx.Err().
+	entityChanged bool
+
+	// EventQueueDepth is the size of the bounded channel sitting between
+	// the ARI event bus and the event publisher workers.
+	EventQueueDepth int
+
+	// EventPublishers is the number of worker goroutines publishing
+	// events from the queue to MessageBus.
+	EventPublishers int
+
+	// EventOverflowPolicy controls how excess events are handled once the
+	// event queue is full.
+	EventOverflowPolicy EventOverflowPolicy
+
+	eventPipeline *eventPipeline
+
+	// Federation, if true, enables cluster federation: requests targeting
+	// a different, known peer Server's AsteriskID are forwarded to that
+	// peer over MessageBus rather than rejected.
+	Federation bool
+
+	// ForwardToken, if set, is the bearer token this server requires of
+	// any request forwarded to it by a peer. It is never broadcast (it is
+	// not carried on Announcement); peers which need to satisfy it must be
+	// configured with the matching entry in PeerTokens out of band.
+	ForwardToken string
+
+	// PeerTokens maps a peer's AsteriskID to the bearer token this server
+	// should present when forwarding a request to that peer.
+	PeerTokens map[string]string
+
+	// MaxForwardHops bounds how many times a request may be relayed
+	// between federated peers before it is rejected, to prevent
+	// forwarding loops.
+	MaxForwardHops int
+
+	peers *peerRegistry
 }
 
 // New returns a new Server
@@ -52,18 +136,39 @@ func New() *Server {
 	log := log15.New()
 	log.SetHandler(log15.DiscardHandler())
 
-	return &Server{
-		MBPrefix: "ari.",
-		readyCh:  make(chan struct{}),
-		Dialog:   dialog.NewMemManager(),
-		Log:      log,
+	s := &Server{
+		MBPrefix:        "ari.",
+		readyCh:         make(chan struct{}),
+		Dialog:          dialog.NewMemManager(),
+		Log:             log,
+		WorkerPoolSize:  50,
+		QueueDepth:      256,
+		PerKindLimits:   make(map[string]int),
+		EventQueueDepth: 1024,
+		EventPublishers: runtime.NumCPU(),
+		DrainTimeout:    5 * time.Second,
+		PeerTokens:      make(map[string]string),
+		MaxForwardHops:  1,
+		peers:           newPeerRegistry(),
 	}
+
+	s.registry = newHandlerRegistry(s.notImplemented)
+	s.registerBuiltins()
+	s.registerFederation()
+
+	return s
 }
 
 // Listen runs the given server, listening to ARI and MessageBus, as specified
 func (s *Server) Listen(ctx context.Context, ariOpts *native.Options, messagebusURL string) (err error) {
 	ctx, cancel := context.WithCancel(ctx)
 	s.cancel = cancel
+	s.stoppedCh = make(chan struct{})
+	defer close(s.stoppedCh)
+
+	if s.HandleSignals {
+		s.trapSignals(ctx)
+	}
 
 	// Connect to ARI
 	s.ari, err = native.Connect(ariOpts)
@@ -102,6 +207,12 @@ func (s *Server) Listen(ctx context.Context, ariOpts *native.Options, messagebus
 func (s *Server) ListenOn(ctx context.Context, a ari.Client, n *nats.EncodedConn) error {
 	ctx, cancel := context.WithCancel(ctx)
 	s.cancel = cancel
+	s.stoppedCh = make(chan struct{})
+	defer close(s.stoppedCh)
+
+	if s.HandleSignals {
+		s.trapSignals(ctx)
+	}
 
 	s.ari = a
 	s.mbus = messagebus.NewNatsBus(
@@ -124,15 +235,6 @@ func (s *Server) Ready() <-chan struct{} {
 func (s *Server) listen(ctx context.Context) error {
 	s.Log.Debug("starting listener")
 
-	var wg closeGroup
-	defer func() {
-		select {
-		case <-wg.Done():
-		case <-time.After(500 * time.Millisecond):
-			panic("timeout waiting for shutdown of sub components")
-		}
-	}()
-
 	// First, get the Asterisk ID
 
 	ret, err := s.ari.Asterisk().Info(nil)
@@ -148,16 +250,42 @@ func (s *Server) listen(ctx context.Context) error {
 	// Store the ARI application name for top-level access
 	s.Application = s.ari.ApplicationName()
 
+	// Tear down any worker pool left over from a previous listen/Reconnect
+	// cycle before installing a fresh one, so its workers don't leak,
+	// blocked forever on an abandoned queue.
+	if s.pool != nil {
+		drainCtx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+		if err := s.pool.drain(drainCtx); err != nil {
+			s.Log.Warn("previous worker pool did not drain cleanly", "error", err)
+		}
+		cancel()
+	}
+
+	// Construct the bounded worker pool which services dispatched requests
+	s.pool = newWorkerPool(s.WorkerPoolSize, s.PerKindLimits, s.QueueDepth, s.StatsHook)
+
 	//
 	// Listen on the initial MessageBus subjects
 	//
 
+	// established tracks the subscriptions opened so far, so that if a
+	// later subscription fails to establish, the earlier ones are not
+	// leaked on the early return.
+	var established []func() error
+	unsubscribeEstablished := func() {
+		for _, fn := range established {
+			if err := fn(); err != nil {
+				s.Log.Warn("failed to unsubscribe from MessageBus", "error", err)
+			}
+		}
+	}
+
 	// ping handler
 	testPingSub, err := s.mbus.SubscribePing(proxy.PingSubject(s.MBPrefix), s.pingHandler)
 	if err != nil {
 		return eris.Wrap(err, "failed to subscribe to pings")
 	}
-	defer wg.Add(testPingSub.Unsubscribe)
+	established = append(established, testPingSub.Unsubscribe)
 
 	// get a contextualized request handler
 	requestHandler := s.newRequestHandler(ctx)
@@ -177,36 +305,51 @@ func (s *Server) listen(ctx context.Context) error {
 	requestsSub, err := s.mbus.SubscribeRequests(subjects, requestHandler)
 	if err != nil {
 		s.Log.Error("%v", err)
+		unsubscribeEstablished()
 		return eris.Wrap(err, "failed to create requests subscription")
 	}
-	defer wg.Add(requestsSub.Unsubscribe)()
+	established = append(established, requestsSub.Unsubscribe)
 
 	// create handlers
 	allCreate, err := s.mbus.SubscribeCreateRequest(proxy.Subject(s.MBPrefix, "create", "", ""), "ariproxy", requestHandler)
 	if err != nil {
+		unsubscribeEstablished()
 		return eris.Wrap(err, "failed to create create-all subscription")
 	}
-	defer wg.Add(allCreate.Unsubscribe)()
+	established = append(established, allCreate.Unsubscribe)
 	appCreate, err := s.mbus.SubscribeCreateRequest(proxy.Subject(s.MBPrefix, "create", s.Application, ""), "ariproxy", requestHandler)
 	if err != nil {
+		unsubscribeEstablished()
 		return eris.Wrap(err, "failed to create create-app subscription")
 	}
-	defer wg.Add(appCreate.Unsubscribe)()
+	established = append(established, appCreate.Unsubscribe)
 	idCreate, err := s.mbus.SubscribeCreateRequest(proxy.Subject(s.MBPrefix, "create", s.Application, s.AsteriskID), "ariproxy", requestHandler)
 	if err != nil {
+		unsubscribeEstablished()
 		return eris.Wrap(err, "failed to create create-id subscription")
 	}
-	defer wg.Add(idCreate.Unsubscribe)()
+	established = append(established, idCreate.Unsubscribe)
 
 	// Run the periodic announcer
 	go s.runAnnouncer(ctx)
 
+	// Construct the event pipeline synchronously, before runEventHandler is
+	// started, so that it is never nil if ctx is canceled (and listen goes
+	// on to read s.eventPipeline at drain time) before that goroutine gets
+	// a chance to run.
+	s.eventPipeline = newEventPipeline(s.EventQueueDepth, s.EventOverflowPolicy, s.StatsHook)
+
 	// Run the event handler
 	go s.runEventHandler(ctx)
 
 	// Run the entity check handler
 	go s.runEntityChecker(ctx)
 
+	// Run the peer listener, for cluster federation
+	if s.Federation {
+		go s.runPeerListener(ctx)
+	}
+
 	// TODO: run the dialog cleanup routine (remove bindings for entities which no longer exist)
 	// go s.runDialogCleaner(ctx)
 
@@ -217,9 +360,49 @@ func (s *Server) listen(ctx context.Context) error {
 
 	// Wait for context closure to exit
 	<-ctx.Done()
+
+	// Unsubscribe from MessageBus before draining, not after: otherwise new
+	// requests could keep arriving and being accepted by s.pool.submit
+	// throughout the drain window, only to be left stranded once drain
+	// decides the pool is quiescent and stops its workers.
+	unsubscribeEstablished()
+
+	// Drain in-flight requests and events, bounded by DrainTimeout, before
+	// this function returns and the deferred ARI/MessageBus Close calls in
+	// Listen run out from under any handler still mid-flight.
+	drainCtx, cancel := context.WithTimeout(context.Background(), s.DrainTimeout)
+	if err := s.pool.drain(drainCtx); err != nil {
+		s.Log.Warn("worker pool did not drain before shutdown timeout", "error", err)
+	}
+	if err := s.eventPipeline.drain(drainCtx); err != nil {
+		s.Log.Warn("event pipeline did not drain before shutdown timeout", "error", err)
+	}
+	cancel()
+
+	if s.entityChanged {
+		return ErrEntityChanged
+	}
 	return ctx.Err()
 }
 
+// trapSignals arranges for SIGINT/SIGTERM to trigger a graceful Shutdown
+func (s *Server) trapSignals(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		defer signal.Stop(sigCh)
+		select {
+		case sig := <-sigCh:
+			s.Log.Info("received signal, shutting down", "signal", sig)
+			if err := s.Shutdown(context.Background()); err != nil {
+				s.Log.Error("failed to shut down cleanly", "error", err)
+			}
+		case <-ctx.Done():
+		}
+	}()
+}
+
 // runEntityChecker runs the periodic check againt Asterisk entity id
 func (s *Server) runEntityChecker(ctx context.Context) {
 	ticker := time.NewTicker(proxy.EntityCheckInterval)
@@ -237,8 +420,14 @@ func (s *Server) runEntityChecker(ctx context.Context) {
 			}
 			if s.AsteriskID != info.SystemInfo.EntityID {
 				s.Log.Warn("system entitiy id changed", "old", s.AsteriskID, "new", info.SystemInfo.EntityID)
-				// We need to exit with non-zero to make sure systemd restarts when service defined with Restart=on-failure
-				os.Exit(1)
+				s.entityChanged = true
+
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), entityChangeShutdownTimeout)
+				if err := s.Shutdown(shutdownCtx); err != nil {
+					s.Log.Error("failed to shut down cleanly after entity change", "error", err)
+				}
+				cancel()
+				return
 			}
 		}
 	}
@@ -267,29 +456,42 @@ func (s *Server) announce() {
 	})
 }
 
-// runEventHandler processes events which are received from ARI
+// runEventHandler reads events from ARI and hands them off to the bounded
+// eventPipeline, so that a slow MessageBus publisher cannot stall ARI event
+// consumption.
 func (s *Server) runEventHandler(ctx context.Context) {
 	sub := s.ari.Bus().Subscribe(nil, ari.Events.All)
 	defer sub.Cancel()
 
+	publishers := s.EventPublishers
+	if publishers < 1 {
+		publishers = 1
+	}
+	for i := 0; i < publishers; i++ {
+		go s.eventPipeline.run(s.publishEventEnvelope)
+	}
+
+	s.Log.Debug("listening for events", "application", s.Application)
 	for {
-		s.Log.Debug("listening for events", "application", s.Application)
 		select {
 		case <-ctx.Done():
 			return
 		case e := <-sub.Events():
 			s.Log.Debug("event received", "kind", e.GetType())
+			s.eventPipeline.enqueue(eventEnvelope{event: e, dialogs: s.dialogsForEvent(e)})
+		}
+	}
+}
 
-			// Publish event to canonical destination
-			s.publishEvent(fmt.Sprintf("%sevent.%s.%s", s.MBPrefix, s.Application, s.AsteriskID), e)
+// publishEventEnvelope publishes a single pipelined event to its canonical
+// destination and to any dialogs it is associated with.
+func (s *Server) publishEventEnvelope(env eventEnvelope) {
+	s.publishEvent(fmt.Sprintf("%sevent.%s.%s", s.MBPrefix, s.Application, s.AsteriskID), env.event)
 
-			// Publish event to any associated dialogs
-			for _, d := range s.dialogsForEvent(e) {
-				de := e
-				de.SetDialog(d)
-				s.publishEvent(fmt.Sprintf("%sdialogevent.%s", s.MBPrefix, d), de)
-			}
-		}
+	for _, d := range env.dialogs {
+		de := env.event
+		de.SetDialog(d)
+		s.publishEvent(fmt.Sprintf("%sdialogevent.%s", s.MBPrefix, d), de)
 	}
 }
 
@@ -328,248 +530,148 @@ func (s *Server) newRequestHandler(ctx context.Context) func(subject string, rep
 			s.sendError(reply, eris.New("ARI connection is down"))
 			return
 		}
-		go s.dispatchRequest(ctx, reply, req)
+		reqCtx := withRequestSubject(ctx, subject)
+		if err := s.pool.submit(reqCtx, reply, req, s.dispatchRequest); err != nil {
+			s.sendError(reply, err)
+		}
 	}
 }
 
-// TODO: see if there is a more programmatic approach to this
-// nolint: gocyclo
-func (s *Server) dispatchRequest(ctx context.Context, reply string, req *proxy.Request) {
-	var f func(context.Context, string, *proxy.Request)
+// registerBuiltins registers all of the proxy's built-in ARI operations
+// against the server's HandlerRegistry.  Downstream users may override any
+// of these, or register entirely new Kinds, via Server.Handle.
+func (s *Server) registerBuiltins() {
+	builtins := map[string]RequestHandler{
+		"ApplicationData":           s.applicationData,
+		"ApplicationGet":            s.applicationGet,
+		"ApplicationList":           s.applicationList,
+		"ApplicationSubscribe":      s.applicationSubscribe,
+		"ApplicationUnsubscribe":    s.applicationUnsubscribe,
+		"AsteriskConfigData":        s.asteriskConfigData,
+		"AsteriskConfigDelete":      s.asteriskConfigDelete,
+		"AsteriskConfigUpdate":      s.asteriskConfigUpdate,
+		"AsteriskLoggingCreate":     s.asteriskLoggingCreate,
+		"AsteriskLoggingData":       s.asteriskLoggingData,
+		"AsteriskLoggingDelete":     s.asteriskLoggingDelete,
+		"AsteriskLoggingGet":        s.asteriskLoggingGet,
+		"AsteriskLoggingList":       s.asteriskLoggingList,
+		"AsteriskLoggingRotate":     s.asteriskLoggingRotate,
+		"AsteriskModuleData":        s.asteriskModuleData,
+		"AsteriskModuleGet":         s.asteriskModuleGet,
+		"AsteriskModuleLoad":        s.asteriskModuleLoad,
+		"AsteriskModuleList":        s.asteriskModuleList,
+		"AsteriskModuleReload":      s.asteriskModuleReload,
+		"AsteriskModuleUnload":      s.asteriskModuleUnload,
+		"AsteriskInfo":              s.asteriskInfo,
+		"AsteriskVariableGet":       s.asteriskVariableGet,
+		"AsteriskVariableSet":       s.asteriskVariableSet,
+		"BridgeAddChannel":          s.bridgeAddChannel,
+		"BridgeCreate":              s.bridgeCreate,
+		"BridgeStageCreate":         s.bridgeStageCreate,
+		"BridgeData":                s.bridgeData,
+		"BridgeDelete":              s.bridgeDelete,
+		"BridgeGet":                 s.bridgeGet,
+		"BridgeList":                s.bridgeList,
+		"BridgeMOH":                 s.bridgeMOH,
+		"BridgeStopMOH":             s.bridgeStopMOH,
+		"BridgePlay":                s.bridgePlay,
+		"BridgeStagePlay":           s.bridgeStagePlay,
+		"BridgeRecord":              s.bridgeRecord,
+		"BridgeStageRecord":         s.bridgeStageRecord,
+		"BridgeRemoveChannel":       s.bridgeRemoveChannel,
+		"BridgeSubscribe":           s.bridgeSubscribe,
+		"BridgeUnsubscribe":         s.bridgeUnsubscribe,
+		"BridgeVideoSource":         s.bridgeVideoSource,
+		"BridgeVideoSourceDelete":   s.bridgeVideoSourceDelete,
+		"ChannelAnswer":             s.channelAnswer,
+		"ChannelBusy":               s.channelBusy,
+		"ChannelCongestion":         s.channelCongestion,
+		"ChannelCreate":             s.channelCreate,
+		"ChannelContinue":           s.channelContinue,
+		"ChannelData":               s.channelData,
+		"ChannelDial":               s.channelDial,
+		"ChannelGet":                s.channelGet,
+		"ChannelHangup":             s.channelHangup,
+		"ChannelHold":               s.channelHold,
+		"ChannelList":               s.channelList,
+		"ChannelMOH":                s.channelMOH,
+		"ChannelMove":               s.channelMove,
+		"ChannelMute":               s.channelMute,
+		"ChannelOriginate":          s.channelOriginate,
+		"ChannelStageOriginate":     s.channelStageOriginate,
+		"ChannelPlay":               s.channelPlay,
+		"ChannelStagePlay":          s.channelStagePlay,
+		"ChannelRecord":             s.channelRecord,
+		"ChannelStageRecord":        s.channelStageRecord,
+		"ChannelRing":               s.channelRing,
+		"ChannelSendDTMF":           s.channelSendDTMF,
+		"ChannelSilence":            s.channelSilence,
+		"ChannelSnoop":              s.channelSnoop,
+		"ChannelStageSnoop":         s.channelStageSnoop,
+		"ChannelExternalMedia":      s.channelExternalMedia,
+		"ChannelStageExternalMedia": s.channelStageExternalMedia,
+		"ChannelStopHold":           s.channelStopHold,
+		"ChannelStopMOH":            s.channelStopMOH,
+		"ChannelStopRing":           s.channelStopRing,
+		"ChannelStopSilence":        s.channelStopSilence,
+		"ChannelSubscribe":          s.channelSubscribe,
+		"ChannelUnmute":             s.channelUnmute,
+		"ChannelVariableGet":        s.channelVariableGet,
+		"ChannelVariableSet":        s.channelVariableSet,
+		"DeviceStateData":           s.deviceStateData,
+		"DeviceStateDelete":         s.deviceStateDelete,
+		"DeviceStateGet":            s.deviceStateGet,
+		"DeviceStateList":           s.deviceStateList,
+		"DeviceStateUpdate":         s.deviceStateUpdate,
+		"EndpointData":              s.endpointData,
+		"EndpointGet":               s.endpointGet,
+		"EndpointList":              s.endpointList,
+		"EndpointListByTech":        s.endpointListByTech,
+		"MailboxData":               s.mailboxData,
+		"MailboxDelete":             s.mailboxDelete,
+		"MailboxGet":                s.mailboxGet,
+		"MailboxList":               s.mailboxList,
+		"MailboxUpdate":             s.mailboxUpdate,
+		"PlaybackControl":           s.playbackControl,
+		"PlaybackData":              s.playbackData,
+		"PlaybackGet":               s.playbackGet,
+		"PlaybackStop":              s.playbackStop,
+		"PlaybackSubscribe":         s.playbackSubscribe,
+		"RecordingStoredCopy":       s.recordingStoredCopy,
+		"RecordingStoredData":       s.recordingStoredData,
+		"RecordingStoredDelete":     s.recordingStoredDelete,
+		"RecordingStoredGet":        s.recordingStoredGet,
+		"RecordingStoredList":       s.recordingStoredList,
+		"RecordingLiveData":         s.recordingLiveData,
+		"RecordingLiveGet":          s.recordingLiveGet,
+		"RecordingLiveMute":         s.recordingLiveMute,
+		"RecordingLivePause":        s.recordingLivePause,
+		"RecordingLiveResume":       s.recordingLiveResume,
+		"RecordingLiveScrap":        s.recordingLiveScrap,
+		"RecordingLiveSubscribe":    s.recordingLiveSubscribe,
+		"RecordingLiveStop":         s.recordingLiveStop,
+		"RecordingLiveUnmute":       s.recordingLiveUnmute,
+		"SoundData":                 s.soundData,
+		"SoundList":                 s.soundList,
+		"ChannelUserEvent":          s.channelUserEvent,
+	}
 
-	s.Log.Debug("received request", "kind", req.Kind)
-	switch req.Kind {
-	case "ApplicationData":
-		f = s.applicationData
-	case "ApplicationGet":
-		f = s.applicationGet
-	case "ApplicationList":
-		f = s.applicationList
-	case "ApplicationSubscribe":
-		f = s.applicationSubscribe
-	case "ApplicationUnsubscribe":
-		f = s.applicationUnsubscribe
-	case "AsteriskConfigData":
-		f = s.asteriskConfigData
-	case "AsteriskConfigDelete":
-		f = s.asteriskConfigDelete
-	case "AsteriskConfigUpdate":
-		f = s.asteriskConfigUpdate
-	case "AsteriskLoggingCreate":
-		f = s.asteriskLoggingCreate
-	case "AsteriskLoggingData":
-		f = s.asteriskLoggingData
-	case "AsteriskLoggingDelete":
-		f = s.asteriskLoggingDelete
-	case "AsteriskLoggingGet":
-		f = s.asteriskLoggingGet
-	case "AsteriskLoggingList":
-		f = s.asteriskLoggingList
-	case "AsteriskLoggingRotate":
-		f = s.asteriskLoggingRotate
-	case "AsteriskModuleData":
-		f = s.asteriskModuleData
-	case "AsteriskModuleGet":
-		f = s.asteriskModuleGet
-	case "AsteriskModuleLoad":
-		f = s.asteriskModuleLoad
-	case "AsteriskModuleList":
-		f = s.asteriskModuleList
-	case "AsteriskModuleReload":
-		f = s.asteriskModuleReload
-	case "AsteriskModuleUnload":
-		f = s.asteriskModuleUnload
-	case "AsteriskInfo":
-		f = s.asteriskInfo
-	case "AsteriskVariableGet":
-		f = s.asteriskVariableGet
-	case "AsteriskVariableSet":
-		f = s.asteriskVariableSet
-	case "BridgeAddChannel":
-		f = s.bridgeAddChannel
-	case "BridgeCreate":
-		f = s.bridgeCreate
-	case "BridgeStageCreate":
-		f = s.bridgeStageCreate
-	case "BridgeData":
-		f = s.bridgeData
-	case "BridgeDelete":
-		f = s.bridgeDelete
-	case "BridgeGet":
-		f = s.bridgeGet
-	case "BridgeList":
-		f = s.bridgeList
-	case "BridgeMOH":
-		f = s.bridgeMOH
-	case "BridgeStopMOH":
-		f = s.bridgeStopMOH
-	case "BridgePlay":
-		f = s.bridgePlay
-	case "BridgeStagePlay":
-		f = s.bridgeStagePlay
-	case "BridgeRecord":
-		f = s.bridgeRecord
-	case "BridgeStageRecord":
-		f = s.bridgeStageRecord
-	case "BridgeRemoveChannel":
-		f = s.bridgeRemoveChannel
-	case "BridgeSubscribe":
-		f = s.bridgeSubscribe
-	case "BridgeUnsubscribe":
-		f = s.bridgeUnsubscribe
-	case "BridgeVideoSource":
-		f = s.bridgeVideoSource
-	case "BridgeVideoSourceDelete":
-		f = s.bridgeVideoSourceDelete
-	case "ChannelAnswer":
-		f = s.channelAnswer
-	case "ChannelBusy":
-		f = s.channelBusy
-	case "ChannelCongestion":
-		f = s.channelCongestion
-	case "ChannelCreate":
-		f = s.channelCreate
-	case "ChannelContinue":
-		f = s.channelContinue
-	case "ChannelData":
-		f = s.channelData
-	case "ChannelDial":
-		f = s.channelDial
-	case "ChannelGet":
-		f = s.channelGet
-	case "ChannelHangup":
-		f = s.channelHangup
-	case "ChannelHold":
-		f = s.channelHold
-	case "ChannelList":
-		f = s.channelList
-	case "ChannelMOH":
-		f = s.channelMOH
-	case "ChannelMove":
-		f = s.channelMove
-	case "ChannelMute":
-		f = s.channelMute
-	case "ChannelOriginate":
-		f = s.channelOriginate
-	case "ChannelStageOriginate":
-		f = s.channelStageOriginate
-	case "ChannelPlay":
-		f = s.channelPlay
-	case "ChannelStagePlay":
-		f = s.channelStagePlay
-	case "ChannelRecord":
-		f = s.channelRecord
-	case "ChannelStageRecord":
-		f = s.channelStageRecord
-	case "ChannelRing":
-		f = s.channelRing
-	case "ChannelSendDTMF":
-		f = s.channelSendDTMF
-	case "ChannelSilence":
-		f = s.channelSilence
-	case "ChannelSnoop":
-		f = s.channelSnoop
-	case "ChannelStageSnoop":
-		f = s.channelStageSnoop
-	case "ChannelExternalMedia":
-		f = s.channelExternalMedia
-	case "ChannelStageExternalMedia":
-		f = s.channelStageExternalMedia
-	case "ChannelStopHold":
-		f = s.channelStopHold
-	case "ChannelStopMOH":
-		f = s.channelStopMOH
-	case "ChannelStopRing":
-		f = s.channelStopRing
-	case "ChannelStopSilence":
-		f = s.channelStopSilence
-	case "ChannelSubscribe":
-		f = s.channelSubscribe
-	case "ChannelUnmute":
-		f = s.channelUnmute
-	case "ChannelVariableGet":
-		f = s.channelVariableGet
-	case "ChannelVariableSet":
-		f = s.channelVariableSet
-	case "DeviceStateData":
-		f = s.deviceStateData
-	case "DeviceStateDelete":
-		f = s.deviceStateDelete
-	case "DeviceStateGet":
-		f = s.deviceStateGet
-	case "DeviceStateList":
-		f = s.deviceStateList
-	case "DeviceStateUpdate":
-		f = s.deviceStateUpdate
-	case "EndpointData":
-		f = s.endpointData
-	case "EndpointGet":
-		f = s.endpointGet
-	case "EndpointList":
-		f = s.endpointList
-	case "EndpointListByTech":
-		f = s.endpointListByTech
-	case "MailboxData":
-		f = s.mailboxData
-	case "MailboxDelete":
-		f = s.mailboxDelete
-	case "MailboxGet":
-		f = s.mailboxGet
-	case "MailboxList":
-		f = s.mailboxList
-	case "MailboxUpdate":
-		f = s.mailboxUpdate
-	case "PlaybackControl":
-		f = s.playbackControl
-	case "PlaybackData":
-		f = s.playbackData
-	case "PlaybackGet":
-		f = s.playbackGet
-	case "PlaybackStop":
-		f = s.playbackStop
-	case "PlaybackSubscribe":
-		f = s.playbackSubscribe
-	case "RecordingStoredCopy":
-		f = s.recordingStoredCopy
-	case "RecordingStoredData":
-		f = s.recordingStoredData
-	case "RecordingStoredDelete":
-		f = s.recordingStoredDelete
-	case "RecordingStoredGet":
-		f = s.recordingStoredGet
-	case "RecordingStoredList":
-		f = s.recordingStoredList
-	case "RecordingLiveData":
-		f = s.recordingLiveData
-	case "RecordingLiveGet":
-		f = s.recordingLiveGet
-	case "RecordingLiveMute":
-		f = s.recordingLiveMute
-	case "RecordingLivePause":
-		f = s.recordingLivePause
-	case "RecordingLiveResume":
-		f = s.recordingLiveResume
-	case "RecordingLiveScrap":
-		f = s.recordingLiveScrap
-	case "RecordingLiveSubscribe":
-		f = s.recordingLiveSubscribe
-	case "RecordingLiveStop":
-		f = s.recordingLiveStop
-	case "RecordingLiveUnmute":
-		f = s.recordingLiveUnmute
-	case "SoundData":
-		f = s.soundData
-	case "SoundList":
-		f = s.soundList
-	case "ChannelUserEvent":
-		f = s.channelUserEvent
-	default:
-		f = func(ctx context.Context, reply string, req *proxy.Request) {
-			s.sendError(reply, eris.New("Not implemented"))
-		}
+	for kind, fn := range builtins {
+		s.registry.handle(kind, fn)
 	}
+}
+
+// dispatchRequest runs req through the handler registered for req.Kind,
+// wrapped in the server's middleware chain.
+func (s *Server) dispatchRequest(ctx context.Context, reply string, req *proxy.Request) {
+	s.Log.Debug("received request", "kind", req.Kind)
+	s.registry.dispatch(ctx, reply, req)
+}
 
-	f(ctx, reply, req)
+// notImplemented is the default fallback handler for any request Kind which
+// has no registered handler.
+func (s *Server) notImplemented(ctx context.Context, reply string, req *proxy.Request) {
+	s.sendError(reply, eris.New("Not implemented"))
 }
 
 func (s *Server) sendError(reply string, err error) {
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/CyCoreSystems/ari/v5/client/native"
+	"github.com/rotisserie/eris"
+)
+
+// entityChangeShutdownTimeout bounds how long runEntityChecker will wait for
+// a graceful Shutdown before giving up and returning anyway.
+const entityChangeShutdownTimeout = 5 * time.Second
+
+// ErrEntityChanged is returned by Listen/ListenOn when the Asterisk EntityID
+// is found to have changed out from under the server.  Rather than
+// terminating the process, the embedder may inspect this error and decide
+// whether to Reconnect, construct a new Server, or exit.
+var ErrEntityChanged = eris.New("asterisk entity id changed")
+
+// Shutdown gracefully stops the server: it publishes one final announce,
+// the same presence Announcement that runAnnouncer sends periodically (it
+// carries no going-away marker), then cancels the internal context. This
+// causes listen to unsubscribe from MessageBus and, bounded by its own
+// DrainTimeout, wait for the worker pool and event pipeline to finish any
+// in-flight work before returning. Shutdown itself returns once that unwind
+// completes or ctx is done, whichever comes first.  Under Listen, the
+// underlying ARI and MessageBus connections are closed by its deferred
+// cleanup only after listen has returned, so Shutdown's ctx should allow
+// enough time for DrainTimeout to elapse.  ListenOn does not close the
+// connections it was given.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.mbus != nil && s.ari != nil && s.ari.Connected() {
+		s.announce()
+	}
+
+	if s.cancel != nil {
+		s.cancel()
+	}
+
+	if s.stoppedCh == nil {
+		return nil
+	}
+
+	select {
+	case <-s.stoppedCh:
+		return nil
+	case <-ctx.Done():
+		return eris.Wrap(ctx.Err(), "timed out waiting for server shutdown")
+	}
+}
+
+// Reconnect re-runs Asterisk-ID discovery and reopens the MessageBus
+// subscriptions without tearing down the existing MessageBus connection.
+// It is intended for use after Listen/ListenOn has returned ErrEntityChanged.
+func (s *Server) Reconnect(ctx context.Context, ariOpts *native.Options) error {
+	a, err := native.Connect(ariOpts)
+	if err != nil {
+		return eris.Wrap(err, "failed to reconnect to ARI")
+	}
+
+	if s.ari != nil {
+		s.ari.Close()
+	}
+	s.ari = a
+	s.entityChanged = false
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.stoppedCh = make(chan struct{})
+	defer close(s.stoppedCh)
+
+	if s.HandleSignals {
+		s.trapSignals(ctx)
+	}
+
+	return s.listen(ctx)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CyCoreSystems/ari-proxy/v5/proxy"
+	"github.com/rotisserie/eris"
+)
+
+// ErrOverloaded is returned to the client over the reply subject when the
+// worker pool's submission queue is full, rather than silently piling up
+// goroutines.
+var ErrOverloaded = eris.New("request queue is full")
+
+// StatsHook receives point-in-time gauge readings (worker pool depth, queue
+// depth) so operators can alert on load before Asterisk itself falls over.
+type StatsHook func(metric string, value float64)
+
+// drainPollInterval is how often drain polls for quiescence while waiting
+// for a pool or pipeline to empty out.
+const drainPollInterval = 10 * time.Millisecond
+
+// workItem is a single dispatch submitted to the WorkerPool
+type workItem struct {
+	ctx   context.Context
+	reply string
+	req   *proxy.Request
+	fn    RequestHandler
+}
+
+// WorkerPool bounds the number of goroutines concurrently servicing
+// dispatched requests, with an optional per-Kind concurrency limit and a
+// bounded submission queue.
+type WorkerPool struct {
+	queue    chan workItem
+	stats    StatsHook
+	inFlight int32
+
+	// queued counts items which have left the submission queue but are
+	// still waiting on a saturated Kind's semaphore, so that drain does
+	// not mistake them for completed work, and so operators can see this
+	// backlog through StatsHook.
+	queued int32
+
+	kindSems map[string]chan struct{}
+
+	// kindWaiters bounds, per Kind, how many waiter goroutines may be
+	// parked at once trying to acquire that Kind's semaphore. It is sized
+	// off the same PerKindLimits, so the number of goroutines a saturated
+	// Kind can accumulate stays bounded rather than growing one per
+	// queued request.
+	kindWaiters map[string]chan struct{}
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newWorkerPool constructs and starts a WorkerPool of size workers, honoring
+// the given per-Kind concurrency limits, with a submission queue of depth
+// queueDepth.
+func newWorkerPool(size int, kindLimits map[string]int, queueDepth int, stats StatsHook) *WorkerPool {
+	p := &WorkerPool{
+		queue:       make(chan workItem, queueDepth),
+		stats:       stats,
+		kindSems:    make(map[string]chan struct{}, len(kindLimits)),
+		kindWaiters: make(map[string]chan struct{}, len(kindLimits)),
+		stopCh:      make(chan struct{}),
+	}
+
+	for kind, limit := range kindLimits {
+		if limit > 0 {
+			p.kindSems[kind] = make(chan struct{}, limit)
+			p.kindWaiters[kind] = make(chan struct{}, limit)
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		go p.worker()
+	}
+
+	return p
+}
+
+// submit enqueues a request for dispatch by fn, returning ErrOverloaded if
+// the submission queue is already full.
+func (p *WorkerPool) submit(ctx context.Context, reply string, req *proxy.Request, fn RequestHandler) error {
+	select {
+	case p.queue <- workItem{ctx: ctx, reply: reply, req: req, fn: fn}:
+		p.reportDepth()
+		return nil
+	default:
+		return ErrOverloaded
+	}
+}
+
+// worker is one of the pool's fixed goroutines; it pulls items off the
+// queue until the pool is stopped.
+func (p *WorkerPool) worker() {
+	for {
+		select {
+		case <-p.stopCh:
+			return
+		case item := <-p.queue:
+			p.dispatch(item)
+		}
+	}
+}
+
+// dispatch runs item, honoring its per-Kind concurrency limit, if any. When
+// that Kind is already at its limit, the item is handed off to a waiter
+// goroutine instead of blocking this worker, so a burst against one
+// throttled Kind cannot starve every other Kind of workers. The number of
+// such waiters is bounded by kindWaiters: once that bound is also hit, this
+// worker blocks directly rather than spawning another waiter, which in turn
+// lets the submission queue back up and ErrOverloaded start firing, instead
+// of growing goroutines without limit.
+func (p *WorkerPool) dispatch(item workItem) {
+	sem, limited := p.kindSems[item.req.Kind]
+	if !limited {
+		p.execute(item, nil)
+		return
+	}
+
+	select {
+	case sem <- struct{}{}:
+		p.execute(item, sem)
+		return
+	default:
+	}
+
+	waiters := p.kindWaiters[item.req.Kind]
+	select {
+	case waiters <- struct{}{}:
+		atomic.AddInt32(&p.queued, 1)
+		p.reportDepth()
+		go func() {
+			defer func() { <-waiters }()
+			select {
+			case sem <- struct{}{}:
+				atomic.AddInt32(&p.queued, -1)
+				p.execute(item, sem)
+			case <-p.stopCh:
+				atomic.AddInt32(&p.queued, -1)
+				p.reportDepth()
+			}
+		}()
+	default:
+		atomic.AddInt32(&p.queued, 1)
+		p.reportDepth()
+		select {
+		case sem <- struct{}{}:
+			atomic.AddInt32(&p.queued, -1)
+			p.execute(item, sem)
+		case <-p.stopCh:
+			atomic.AddInt32(&p.queued, -1)
+			p.reportDepth()
+		}
+	}
+}
+
+// execute runs item.fn, releasing sem (if any) once it returns.
+func (p *WorkerPool) execute(item workItem, sem chan struct{}) {
+	atomic.AddInt32(&p.inFlight, 1)
+	p.reportDepth()
+
+	item.fn(item.ctx, item.reply, item.req)
+
+	if sem != nil {
+		<-sem
+	}
+
+	atomic.AddInt32(&p.inFlight, -1)
+	p.reportDepth()
+}
+
+// drain waits, bounded by ctx, for the submission queue to empty, all
+// in-flight work to finish, and all Kind-saturated waiters to either start
+// running or give up, then stops the pool's worker goroutines.  It is safe
+// to call multiple times.
+func (p *WorkerPool) drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	var err error
+	for {
+		if len(p.queue) == 0 && atomic.LoadInt32(&p.inFlight) == 0 && atomic.LoadInt32(&p.queued) == 0 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+
+	p.stopOnce.Do(func() { close(p.stopCh) })
+	return err
+}
+
+// reportDepth emits the current queue and in-flight depths through the
+// configured StatsHook, if any.
+func (p *WorkerPool) reportDepth() {
+	if p.stats == nil {
+		return
+	}
+	p.stats("worker_pool.queue_depth", float64(len(p.queue)))
+	p.stats("worker_pool.in_flight", float64(atomic.LoadInt32(&p.inFlight)))
+	p.stats("worker_pool.queued", float64(atomic.LoadInt32(&p.queued)))
+}